@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	"image/png"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime/pprof"
@@ -65,13 +68,286 @@ func TrimImage(src *image.RGBA) (dst *image.RGBA) {
 	return src.SubImage(trim).(*image.RGBA)
 }
 
+// moorePixelMask adapts src into the boolean foreground test
+// mooreBoundaryTrace needs: a pixel belongs to the
+// sprite once its alpha exceeds threshold.
+func moorePixelMask(src *image.RGBA, threshold uint8) func(x, y int) bool {
+	b := src.Bounds()
+	return func(x, y int) bool {
+		if x < b.Min.X || x >= b.Max.X || y < b.Min.Y || y >= b.Max.Y {
+			return false
+		}
+		return src.RGBAAt(x, y).A > threshold
+	}
+}
+
+// mooreBoundaryTrace walks the outer boundary of the foreground region
+// (as reported by isFG) starting at start, using Moore-neighbor tracing:
+// at each step it scans the 8 neighbors clockwise from the direction it
+// arrived from and moves to the first foreground pixel it finds. The walk
+// stops on returning to start, on a pixel with no foreground neighbor, or
+// after maxSteps steps. Returns the ordered boundary pixels, in the same
+// coordinate space as isFG.
+func mooreBoundaryTrace(isFG func(x, y int) bool, start image.Point, maxSteps int) []image.Point {
+	// Clockwise neighbor offsets starting at west.
+	dirs := [8]image.Point{
+		{-1, 0}, {-1, -1}, {0, -1}, {1, -1},
+		{1, 0}, {1, 1}, {0, 1}, {-1, 1},
+	}
+
+	boundary := []image.Point{start}
+	current := start
+	// We arrive at start via a left-to-right scan, so the pixel we
+	// backtrack from is the background pixel immediately west of it.
+	backtrackDir := 0
+
+	for step := 0; step < maxSteps; step++ {
+		found := false
+		var next image.Point
+		var nextDir int
+		for i := 1; i <= 8; i++ {
+			dirIdx := (backtrackDir + i) % 8
+			cand := image.Pt(current.X+dirs[dirIdx].X, current.Y+dirs[dirIdx].Y)
+			if isFG(cand.X, cand.Y) {
+				next = cand
+				nextDir = dirIdx
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+
+		backtrackDir = (nextDir + 4) % 8
+		current = next
+
+		if current == start {
+			break
+		}
+		boundary = append(boundary, current)
+	}
+
+	return boundary
+}
+
+// perpendicularDistance returns how far p sits from the line through a
+// and b, for Ramer-Douglas-Peucker.
+func perpendicularDistance(p, a, b image.Point) float64 {
+	if a == b {
+		dx, dy := float64(p.X-a.X), float64(p.Y-a.Y)
+		return math.Sqrt(dx*dx + dy*dy)
+	}
+
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	norm := math.Sqrt(dx*dx + dy*dy)
+	return math.Abs(dy*float64(p.X-a.X)-dx*float64(p.Y-a.Y)) / norm
+}
+
+func rdpRecurse(points []image.Point, start, end int, epsilon float64, keep []bool) {
+	if end <= start+1 {
+		return
+	}
+
+	maxDist := -1.0
+	maxIdx := -1
+	for i := start + 1; i < end; i++ {
+		if d := perpendicularDistance(points[i], points[start], points[end]); d > maxDist {
+			maxDist, maxIdx = d, i
+		}
+	}
+
+	if maxDist > epsilon {
+		keep[maxIdx] = true
+		rdpRecurse(points, start, maxIdx, epsilon, keep)
+		rdpRecurse(points, maxIdx, end, epsilon, keep)
+	}
+}
+
+// farthestPair returns the indices of the two points in points that are
+// farthest apart (by squared distance).
+func farthestPair(points []image.Point) (int, int) {
+	bestI, bestJ, bestD := 0, 0, -1
+	for i := 0; i < len(points); i++ {
+		for j := i + 1; j < len(points); j++ {
+			dx, dy := points[i].X-points[j].X, points[i].Y-points[j].Y
+			if d := dx*dx + dy*dy; d > bestD {
+				bestD, bestI, bestJ = d, i, j
+			}
+		}
+	}
+	return bestI, bestJ
+}
+
+// simplifyClosedRDP reduces the closed polygon points to the subset whose
+// removal would deviate from its neighbors by more than epsilon
+// (Ramer-Douglas-Peucker). Unlike an open polyline, points has no
+// meaningful first/last vertex -- mooreBoundaryTrace's start is just
+// wherever the scan happened to find the first foreground pixel -- so
+// this anchors the simplification on the two points farthest apart
+// around the loop instead of points[0]/points[len-1], splits the loop
+// into the two chains between those anchors, and simplifies each as an
+// open polyline.
+func simplifyClosedRDP(points []image.Point, epsilon float64) []image.Point {
+	if len(points) < 3 {
+		return points
+	}
+
+	i, j := farthestPair(points)
+	if i > j {
+		i, j = j, i
+	}
+
+	chainA := points[i : j+1]
+	chainB := make([]image.Point, 0, len(points)-(j-i)+1)
+	chainB = append(chainB, points[j:]...)
+	chainB = append(chainB, points[:i+1]...)
+
+	keepA := make([]bool, len(chainA))
+	keepA[0], keepA[len(chainA)-1] = true, true
+	rdpRecurse(chainA, 0, len(chainA)-1, epsilon, keepA)
+
+	keepB := make([]bool, len(chainB))
+	keepB[0], keepB[len(chainB)-1] = true, true
+	rdpRecurse(chainB, 0, len(chainB)-1, epsilon, keepB)
+
+	out := make([]image.Point, 0, len(points))
+	for k, keep := range keepA {
+		if keep {
+			out = append(out, chainA[k])
+		}
+	}
+	// chainB's endpoints duplicate chainA's (points[j] and points[i]);
+	// skip them so the anchors aren't doubled in the closed result.
+	for k := 1; k < len(chainB)-1; k++ {
+		if keepB[k] {
+			out = append(out, chainB[k])
+		}
+	}
+
+	return out
+}
+
+// polygonBoundsDiagonal returns the diagonal length of points' bounding
+// box, used as an upper bound when searching for an RDP epsilon.
+func polygonBoundsDiagonal(points []image.Point) float64 {
+	if len(points) == 0 {
+		return 0
+	}
+
+	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
+	for _, p := range points[1:] {
+		minX, minY = minInt(minX, p.X), minInt(minY, p.Y)
+		maxX, maxY = maxInt(maxX, p.X), maxInt(maxY, p.Y)
+	}
+
+	dx, dy := float64(maxX-minX), float64(maxY-minY)
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// simplifyToVertexCount binary-searches for the smallest RDP epsilon that
+// brings points down to at most maxVerts vertices, since RDP is
+// controlled by a distance tolerance rather than a vertex budget.
+func simplifyToVertexCount(points []image.Point, maxVerts int) []image.Point {
+	if maxVerts <= 0 || len(points) <= maxVerts {
+		return points
+	}
+
+	lo, hi := 0.0, polygonBoundsDiagonal(points)
+	best := points
+	for i := 0; i < 20 && hi-lo > 0.01; i++ {
+		mid := (lo + hi) / 2
+		simplified := simplifyClosedRDP(points, mid)
+		if len(simplified) > maxVerts {
+			lo = mid
+		} else {
+			best = simplified
+			hi = mid
+		}
+	}
+	return best
+}
+
+// meshThreshold is the alpha value above which a pixel counts as part of
+// the sprite for mesh tracing.
+const meshThreshold = 0
+
+// traceMesh computes a tight polygon hull around src's non-transparent
+// pixels, simplified to at most maxVerts vertices. Points are in src's
+// local bounds-relative pixel coordinates (0,0 at src.Bounds().Min).
+// Returns nil if src has no pixel with alpha above meshThreshold, or if
+// the traced boundary has fewer than 3 pixels.
+func traceMesh(src *image.RGBA, maxVerts int) []image.Point {
+	isFG := moorePixelMask(src, meshThreshold)
+
+	b := src.Bounds()
+	var start image.Point
+	found := false
+	for y := b.Min.Y; y < b.Max.Y && !found; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if isFG(x, y) {
+				start = image.Pt(x, y)
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	// The walk is deterministic in (pixel, arrival direction), of which
+	// there are at most 8 per pixel, so a walk that has not closed within
+	// that many steps never will.
+	boundary := mooreBoundaryTrace(isFG, start, 8*b.Dx()*b.Dy())
+	if len(boundary) < 3 {
+		return nil
+	}
+
+	simplified := simplifyToVertexCount(boundary, maxVerts)
+
+	local := make([]image.Point, len(simplified))
+	for i, p := range simplified {
+		local[i] = image.Pt(p.X-b.Min.X, p.Y-b.Min.Y)
+	}
+	return local
+}
+
+// premultiplyAlpha converts img's pixels from straight to premultiplied
+// alpha in place -- what image/draw's draw.Over assumes when compositing.
+func premultiplyAlpha(img *image.RGBA) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			o := img.PixOffset(x, y)
+			a := uint32(img.Pix[o+3])
+			img.Pix[o+0] = uint8(uint32(img.Pix[o+0]) * a / 255)
+			img.Pix[o+1] = uint8(uint32(img.Pix[o+1]) * a / 255)
+			img.Pix[o+2] = uint8(uint32(img.Pix[o+2]) * a / 255)
+		}
+	}
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 type AtlasImage struct {
+	ID        string
 	Path      string
 	OrgBounds image.Rectangle
 	Image     *image.RGBA
 	AtlasPos  image.Point
+	Page      int
+	Rotated   bool
+
+	// Mesh is the simplified boundary polygon around the image's
+	// non-transparent pixels, in local pixel coordinates relative to
+	// Image.Bounds().Min. Only populated when Atlas.Mesh is enabled.
+	Mesh []image.Point
+
+	// packed is true once this image has been assigned a position in the
+	// current tree. Pack() uses it to tell already-placed images apart from
+	// ones that still need a slot.
+	packed bool
 }
 
 func (i *AtlasImage) PixelArea() int {
@@ -86,6 +362,66 @@ func (i *AtlasImage) ManhattenSize() int {
 
 type Atlas struct {
 	Images []AtlasImage
+
+	// MaxPages caps how many atlas pages Pack may create; 0 means unlimited.
+	MaxPages int
+
+	// Algo picks the packing algorithm (see algoNames); "" tries all of
+	// them and keeps whichever gives the best occupancy.
+	Algo string
+
+	// SortBy picks the pre-pack sort order (see sortNames); "" tries all
+	// of them and keeps whichever gives the best occupancy.
+	SortBy string
+
+	// AllowRotate lets Pack place a sprite on its side when that scores
+	// better than its upright orientation.
+	AllowRotate bool
+
+	// Padding is the space in pixels Pack leaves between packed sprites.
+	Padding int
+
+	// Extrude is how many pixels of each sprite's edge color
+	// SaveAtlasImage repeats into its padding, to stop bilinear filtering
+	// from bleeding in whatever sits next to it at extreme UVs.
+	Extrude int
+
+	// Premultiply converts loaded images to premultiplied alpha (as
+	// image/draw's draw.Over assumes) before they're blitted.
+	Premultiply bool
+
+	// Mesh enables content-aware trim meshes: each image additionally
+	// gets a simplified polygon hull around its non-transparent pixels,
+	// for runtime rendering with a triangle fan instead of a full quad.
+	Mesh bool
+
+	// MeshVerts caps how many vertices a mesh polygon may have; the hull
+	// is simplified down to this count with Ramer-Douglas-Peucker.
+	MeshVerts int
+
+	nextID    int
+	atlasSize image.Point
+	pages     []Packer
+	algoUsed  string
+}
+
+// NumPages returns how many atlas pages the last successful Pack produced.
+func (a *Atlas) NumPages() int {
+	return len(a.pages)
+}
+
+// ImageByID looks up the image with the given stable ID and returns a
+// copy of its current state (e.g. its AtlasPos after the last Pack).
+// The copy does not follow later Pack calls, so callers look the image up
+// again after every Pack. It is a copy rather than a pointer because
+// AddImage can grow and reallocate Images.
+func (a *Atlas) ImageByID(id string) (AtlasImage, bool) {
+	for i := range a.Images {
+		if a.Images[i].ID == id {
+			return a.Images[i], true
+		}
+	}
+	return AtlasImage{}, false
 }
 
 func NewAtlas() *Atlas {
@@ -93,7 +429,8 @@ func NewAtlas() *Atlas {
 }
 
 func (a *Atlas) AddImage(path string) (err error) {
-	img := AtlasImage{Path: path}
+	img := AtlasImage{Path: path, ID: fmt.Sprintf("img%04d", a.nextID)}
+	a.nextID++
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -112,6 +449,14 @@ func (a *Atlas) AddImage(path string) (err error) {
 
 	img.Image = TrimImage(img.Image)
 
+	if a.Mesh {
+		img.Mesh = traceMesh(img.Image, a.MeshVerts)
+	}
+
+	if a.Premultiply {
+		premultiplyAlpha(img.Image)
+	}
+
 	fmt.Printf("%dx%d -> %dx%d : %s\n", img.OrgBounds.Dx(), img.OrgBounds.Dy(), img.Image.Bounds().Dx(), img.Image.Bounds().Dy(), path)
 
 	a.Images = append(a.Images, img)
@@ -189,57 +534,719 @@ func (n *node) Insert(size image.Point) image.Rectangle {
 	}
 }
 
-func (a *Atlas) PackImages(atlasSize image.Point) (err error) {
-	_ = sort.Sort
+// Packer places rectangles of a given size into a fixed-size bin one at a
+// time. Reset clears it back to a single free region covering bounds;
+// Insert reports where it placed size, or false if nothing fits anymore.
+// Different implementations trade packing quality for simplicity.
+type Packer interface {
+	Insert(size image.Point) (image.Rectangle, bool)
+	Reset(bounds image.Rectangle)
+}
 
-	images := make([]*AtlasImage, len(a.Images), len(a.Images))
-	for i := 0; i < len(a.Images); i++ {
-		images[i] = &a.Images[i]
+// guillotinePacker is the original binary-tree packer: each insert splits
+// a free node in two along whichever axis leaves the least slack, and
+// picks whichever free node leaves the least slack on its short side.
+type guillotinePacker struct {
+	root *node
+}
+
+func (p *guillotinePacker) Reset(bounds image.Rectangle) {
+	p.root = &node{Rect: bounds}
+}
+
+// bestNode finds the free node that fits size with the least slack,
+// without mutating the tree.
+func (p *guillotinePacker) bestNode(size image.Point) (*node, int, bool) {
+	candidates := make(chan nodeCandidate, 1000)
+	go func() {
+		p.root.FindInsertCandidates(size, candidates)
+		close(candidates)
+	}()
+
+	found := false
+	var best nodeCandidate
+	for candidate := range candidates {
+		if !found || candidate.Score < best.Score {
+			found = true
+			best = candidate
+		}
 	}
 
-	root := node{Rect: image.Rect(1, 1, atlasSize.X, atlasSize.Y)}
+	if !found {
+		return nil, 0, false
+	}
 
-	for len(images) > 0 {
-		fmt.Printf("%d images left...\n", len(images))
+	return best.Candidate, best.Score, true
+}
 
-		var bestCandidate nodeCandidate
-		bestImage := -1
+// Score reports the slack size would leave in its best-fitting free node,
+// without placing it.
+func (p *guillotinePacker) Score(size image.Point) (int, bool) {
+	_, score, ok := p.bestNode(size)
+	return score, ok
+}
 
-		for i := 0; i < len(images); i++ {
-			candidates := make(chan nodeCandidate, 1000)
-			go func() {
-				root.FindInsertCandidates(images[i].Image.Bounds().Size().Add(image.Pt(1, 1)), candidates)
-				close(candidates)
-			}()
+func (p *guillotinePacker) Insert(size image.Point) (image.Rectangle, bool) {
+	n, _, ok := p.bestNode(size)
+	if !ok {
+		return image.Rectangle{}, false
+	}
 
-			for {
-				candidate, ok := <-candidates
-				if !ok {
-					break
-				}
-				if bestImage < 0 || candidate.Score < bestCandidate.Score {
-					//fmt.Printf("  -> %d %v\n", i, candidate)
-					bestImage = i
-					bestCandidate = candidate
-				}
+	return n.Insert(size), true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func stringInSlice(s string, slice []string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRectsHeuristic picks which free rectangle a maxRectsPacker uses for
+// the next insert; see Jukka Jylänki's "A Thousand Ways to Pack the Bin".
+type maxRectsHeuristic int
+
+const (
+	maxRectsBestShortSideFit maxRectsHeuristic = iota // BSSF
+	maxRectsBestAreaFit                               // BAF
+	maxRectsBottomLeft                                // BL
+)
+
+// maxRectsPacker keeps the full list of maximal free rectangles (as
+// opposed to guillotinePacker's binary split tree) so later inserts can
+// use space a guillotine split would have thrown away.
+type maxRectsPacker struct {
+	heuristic maxRectsHeuristic
+	free      []image.Rectangle
+}
+
+func (p *maxRectsPacker) Reset(bounds image.Rectangle) {
+	p.free = []image.Rectangle{bounds}
+}
+
+// bestFree finds the free rectangle that scores best for size under this
+// packer's heuristic, without mutating the free list.
+func (p *maxRectsPacker) bestFree(size image.Point) (idx, score1, score2 int, ok bool) {
+	idx = -1
+
+	for i, f := range p.free {
+		if size.X > f.Dx() || size.Y > f.Dy() {
+			continue
+		}
+
+		var s1, s2 int
+		switch p.heuristic {
+		case maxRectsBestAreaFit:
+			s1 = f.Dx()*f.Dy() - size.X*size.Y
+			s2 = minInt(f.Dx()-size.X, f.Dy()-size.Y)
+		case maxRectsBottomLeft:
+			s1 = f.Min.Y + size.Y
+			s2 = f.Min.X
+		default: // maxRectsBestShortSideFit
+			s1 = minInt(f.Dx()-size.X, f.Dy()-size.Y)
+			s2 = maxInt(f.Dx()-size.X, f.Dy()-size.Y)
+		}
+
+		if idx < 0 || s1 < score1 || (s1 == score1 && s2 < score2) {
+			idx, score1, score2 = i, s1, s2
+		}
+	}
+
+	return idx, score1, score2, idx >= 0
+}
+
+// Score reports the primary heuristic score size would get, without
+// placing it.
+func (p *maxRectsPacker) Score(size image.Point) (int, bool) {
+	_, score1, _, ok := p.bestFree(size)
+	return score1, ok
+}
+
+func (p *maxRectsPacker) Insert(size image.Point) (image.Rectangle, bool) {
+	idx, _, _, ok := p.bestFree(size)
+	if !ok {
+		return image.Rectangle{}, false
+	}
+
+	placed := image.Rect(p.free[idx].Min.X, p.free[idx].Min.Y, p.free[idx].Min.X+size.X, p.free[idx].Min.Y+size.Y)
+	p.splitFree(placed)
+	p.pruneFree()
+
+	return placed, true
+}
+
+// splitFree removes every free rectangle that overlaps used and replaces
+// each with up to four leftover rectangles (one per side it overhangs).
+func (p *maxRectsPacker) splitFree(used image.Rectangle) {
+	var next []image.Rectangle
+	for _, f := range p.free {
+		if !f.Overlaps(used) {
+			next = append(next, f)
+			continue
+		}
+
+		if used.Min.X > f.Min.X {
+			next = append(next, image.Rect(f.Min.X, f.Min.Y, used.Min.X, f.Max.Y))
+		}
+		if used.Max.X < f.Max.X {
+			next = append(next, image.Rect(used.Max.X, f.Min.Y, f.Max.X, f.Max.Y))
+		}
+		if used.Min.Y > f.Min.Y {
+			next = append(next, image.Rect(f.Min.X, f.Min.Y, f.Max.X, used.Min.Y))
+		}
+		if used.Max.Y < f.Max.Y {
+			next = append(next, image.Rect(f.Min.X, used.Max.Y, f.Max.X, f.Max.Y))
+		}
+	}
+	p.free = next
+}
+
+// pruneFree drops any free rectangle fully contained in another one, which
+// splitFree tends to produce plenty of.
+func (p *maxRectsPacker) pruneFree() {
+	var next []image.Rectangle
+	for i, f := range p.free {
+		contained := false
+		for j, g := range p.free {
+			if i != j && f.In(g) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			next = append(next, f)
+		}
+	}
+	p.free = next
+}
+
+// skylineSegment is one flat run of the skyline profile: it spans
+// [X, X+Width) at height Y.
+type skylineSegment struct {
+	X, Width, Y int
+}
+
+// skylinePacker is a bottom-left skyline packer: it tracks the outline of
+// already-placed sprites as a sequence of flat segments and always drops
+// the next sprite at the lowest-then-leftmost spot it fits.
+type skylinePacker struct {
+	bounds image.Rectangle
+	line   []skylineSegment
+}
+
+func (p *skylinePacker) Reset(bounds image.Rectangle) {
+	p.bounds = bounds
+	p.line = []skylineSegment{{X: bounds.Min.X, Width: bounds.Dx(), Y: bounds.Min.Y}}
+}
+
+// restY reports the Y a width-wide rect would rest at if its left edge
+// sits at segment i, or ok=false if it runs past the right edge.
+func (p *skylinePacker) restY(i int, width int) (y int, ok bool) {
+	x := p.line[i].X
+	if x+width > p.bounds.Max.X {
+		return 0, false
+	}
+
+	widthLeft := width
+	for widthLeft > 0 && i < len(p.line) {
+		y = maxInt(y, p.line[i].Y)
+		widthLeft -= p.line[i].Width
+		i++
+	}
+
+	return y, widthLeft <= 0
+}
+
+// bestSpot finds the lowest-then-leftmost resting place for size, without
+// updating the skyline.
+func (p *skylinePacker) bestSpot(size image.Point) (idx, y, x int, ok bool) {
+	idx = -1
+
+	for i := range p.line {
+		yy, fits := p.restY(i, size.X)
+		if !fits || yy+size.Y > p.bounds.Max.Y {
+			continue
+		}
+		if idx < 0 || yy < y || (yy == y && p.line[i].X < x) {
+			idx, y, x = i, yy, p.line[i].X
+		}
+	}
+
+	return idx, y, x, idx >= 0
+}
+
+// Score reports the resting height size would land at, without placing
+// it; lower is better, matching the other packers' "lower is better"
+// convention.
+func (p *skylinePacker) Score(size image.Point) (int, bool) {
+	_, y, _, ok := p.bestSpot(size)
+	return y, ok
+}
+
+func (p *skylinePacker) Insert(size image.Point) (image.Rectangle, bool) {
+	_, y, x, ok := p.bestSpot(size)
+	if !ok {
+		return image.Rectangle{}, false
+	}
+
+	rect := image.Rect(x, y, x+size.X, y+size.Y)
+	p.raise(rect)
+
+	return rect, true
+}
+
+// raise updates the skyline to account for rect having just been placed.
+func (p *skylinePacker) raise(rect image.Rectangle) {
+	var next []skylineSegment
+	inserted := false
+	for _, seg := range p.line {
+		segEnd := seg.X + seg.Width
+		if segEnd <= rect.Min.X || seg.X >= rect.Max.X {
+			next = append(next, seg)
+			continue
+		}
+		if seg.X < rect.Min.X {
+			next = append(next, skylineSegment{X: seg.X, Width: rect.Min.X - seg.X, Y: seg.Y})
+		}
+		if !inserted {
+			next = append(next, skylineSegment{X: rect.Min.X, Width: rect.Dx(), Y: rect.Max.Y})
+			inserted = true
+		}
+		if segEnd > rect.Max.X {
+			next = append(next, skylineSegment{X: rect.Max.X, Width: segEnd - rect.Max.X, Y: seg.Y})
+		}
+	}
+	if !inserted {
+		next = append(next, skylineSegment{X: rect.Min.X, Width: rect.Dx(), Y: rect.Max.Y})
+	}
+
+	sort.Slice(next, func(i, j int) bool { return next[i].X < next[j].X })
+
+	var merged []skylineSegment
+	for _, seg := range next {
+		if len(merged) > 0 && merged[len(merged)-1].Y == seg.Y {
+			merged[len(merged)-1].Width += seg.Width
+		} else {
+			merged = append(merged, seg)
+		}
+	}
+	p.line = merged
+}
+
+// algoNames lists every packing algorithm Pack can try, in the order -algo
+// accepts them.
+var algoNames = []string{"guillotine", "maxrects-bssf", "maxrects-baf", "maxrects-bl", "skyline"}
+
+// sortNames lists every pre-pack sort order Pack can try, in the order
+// -sort accepts them.
+var sortNames = []string{"area", "maxside", "perimeter"}
+
+func newPacker(algo string) Packer {
+	switch algo {
+	case "maxrects-bssf":
+		return &maxRectsPacker{heuristic: maxRectsBestShortSideFit}
+	case "maxrects-baf":
+		return &maxRectsPacker{heuristic: maxRectsBestAreaFit}
+	case "maxrects-bl":
+		return &maxRectsPacker{heuristic: maxRectsBottomLeft}
+	case "skyline":
+		return &skylinePacker{}
+	default:
+		return &guillotinePacker{}
+	}
+}
+
+// sortImagesBy returns a copy of images ordered by the given metric,
+// largest first, which tends to pack tighter than inserting in file order.
+func sortImagesBy(images []*AtlasImage, by string) []*AtlasImage {
+	sorted := append([]*AtlasImage(nil), images...)
+
+	var key func(*AtlasImage) int
+	switch by {
+	case "maxside":
+		key = func(i *AtlasImage) int { return maxInt(i.Image.Bounds().Dx(), i.Image.Bounds().Dy()) }
+	case "perimeter":
+		key = func(i *AtlasImage) int { return 2 * (i.Image.Bounds().Dx() + i.Image.Bounds().Dy()) }
+	default: // area
+		key = func(i *AtlasImage) int { return i.PixelArea() }
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return key(sorted[i]) > key(sorted[j]) })
+
+	return sorted
+}
+
+// scorer is implemented by every Packer in this file; it reports how well
+// size would fit right now without placing it, so insertRotated can
+// compare a sprite's upright and rotated footprints before committing to
+// one.
+type scorer interface {
+	Score(size image.Point) (int, bool)
+}
+
+// insertRotated inserts size into p, and -- when allowRotate is set and
+// size isn't square -- also considers size transposed 90 degrees, keeping
+// whichever orientation scores better (ties favour upright). Packers that
+// don't implement scorer just get the upright orientation.
+func insertRotated(p Packer, size image.Point, allowRotate bool) (rect image.Rectangle, rotated bool, ok bool) {
+	if !allowRotate || size.X == size.Y {
+		rect, ok = p.Insert(size)
+		return rect, false, ok
+	}
+
+	s, canScore := p.(scorer)
+	if !canScore {
+		rect, ok = p.Insert(size)
+		return rect, false, ok
+	}
+
+	transposed := image.Pt(size.Y, size.X)
+	score, fits := s.Score(size)
+	tscore, tfits := s.Score(transposed)
+
+	if tfits && (!fits || tscore < score) {
+		rect, ok = p.Insert(transposed)
+		return rect, true, ok
+	}
+
+	rect, ok = p.Insert(size)
+	return rect, false, ok
+}
+
+// placement records where packAttempt put img, without touching img
+// itself -- that only happens once the caller picks a winning attempt.
+type placement struct {
+	img     *AtlasImage
+	pos     image.Point
+	page    int
+	rotated bool
+}
+
+// packAttempt tries to fit every image in order into pages of atlasSize
+// using algo, opening new pages (up to maxPages, 0 meaning unlimited) when
+// the current page is full. It never touches the AtlasImage values
+// themselves, so a failed or losing attempt can simply be discarded.
+func packAttempt(atlasSize image.Point, maxPages int, algo string, allowRotate bool, padding int, order []*AtlasImage) (pages []Packer, placements []placement, err error) {
+	newPage := func() Packer {
+		p := newPacker(algo)
+		p.Reset(image.Rect(1, 1, atlasSize.X, atlasSize.Y))
+		return p
+	}
+
+	pages = []Packer{newPage()}
+	placements = make([]placement, 0, len(order))
+
+	for _, img := range order {
+		size := img.Image.Bounds().Size().Add(image.Pt(padding, padding))
+
+		rect, rotated, ok := insertRotated(pages[len(pages)-1], size, allowRotate)
+		if !ok {
+			if maxPages != 0 && len(pages) >= maxPages {
+				return pages, placements, fmt.Errorf("Failed to fit all images")
+			}
+			pages = append(pages, newPage())
+			rect, rotated, ok = insertRotated(pages[len(pages)-1], size, allowRotate)
+			if !ok {
+				// img is larger than an empty page.
+				return pages, placements, fmt.Errorf("Failed to fit all images")
+			}
+		}
+
+		placements = append(placements, placement{img: img, pos: rect.Min, page: len(pages) - 1, rotated: rotated})
+	}
+
+	return pages, placements, nil
+}
+
+func occupancy(placements []placement, numPages int, atlasSize image.Point) float64 {
+	total := numPages * atlasSize.X * atlasSize.Y
+	if total == 0 {
+		return 0
+	}
+
+	var used int
+	for _, p := range placements {
+		used += p.img.PixelArea()
+	}
+
+	return float64(used) / float64(total)
+}
+
+// fastInsert tries to slot pending images into the free space of an
+// already-packed set of pages, only opening a new page when none of the
+// existing ones have room. It mutates the pending images directly and
+// reports whether every one of them found a spot.
+func fastInsert(pages []Packer, atlasSize image.Point, maxPages int, algo string, allowRotate bool, padding int, pending []*AtlasImage) (newPages []Packer, ok bool) {
+	newPages = pages
+
+	for _, img := range pending {
+		size := img.Image.Bounds().Size().Add(image.Pt(padding, padding))
+
+		placed := false
+		for i, p := range newPages {
+			if rect, rotated, ok := insertRotated(p, size, allowRotate); ok {
+				img.AtlasPos = rect.Min
+				img.Page = i
+				img.Rotated = rotated
+				img.packed = true
+				placed = true
+				break
 			}
 		}
 
-		if bestImage < 0 {
-			return fmt.Errorf("Failed to fit all images")
+		if placed {
+			continue
+		}
+
+		if maxPages != 0 && len(newPages) >= maxPages {
+			return newPages, false
+		}
+
+		np := newPacker(algo)
+		np.Reset(image.Rect(1, 1, atlasSize.X, atlasSize.Y))
+		rect, rotated, ok := insertRotated(np, size, allowRotate)
+		if !ok {
+			return newPages, false
+		}
+
+		newPages = append(newPages, np)
+		img.AtlasPos = rect.Min
+		img.Page = len(newPages) - 1
+		img.Rotated = rotated
+		img.packed = true
+	}
+
+	return newPages, true
+}
+
+// Pack assigns atlas positions to every image that doesn't have one yet.
+// It is re-entrant: images placed by a previous Pack call with the same
+// atlasSize keep their placements when more images are added via
+// AddImage/AddImages, and a call with nothing new to place changes nothing;
+// callers can look an entry up again afterwards by its AtlasImage.ID. When
+// the newly added images fit into the free space of the existing pages,
+// Pack extends them in place (fast path), opening new pages as needed;
+// otherwise, and on a first call or a new atlasSize, it throws the pages
+// away and repacks every image, placed or not, from scratch.
+func (a *Atlas) Pack(atlasSize image.Point) (err error) {
+	if a.Extrude > a.Padding {
+		return fmt.Errorf("Extrude (%d) must not exceed Padding (%d), or it bleeds past the reserved gap into a neighboring sprite", a.Extrude, a.Padding)
+	}
+
+	var pending []*AtlasImage
+	for i := range a.Images {
+		if !a.Images[i].packed {
+			pending = append(pending, &a.Images[i])
+		}
+	}
+
+	if len(a.pages) > 0 && a.atlasSize == atlasSize {
+		if len(pending) == 0 {
+			return nil
+		}
+		if pages, ok := fastInsert(a.pages, atlasSize, a.MaxPages, a.algoUsed, a.AllowRotate, a.Padding, pending); ok {
+			a.pages = pages
+			return nil
+		}
+		fmt.Println("existing atlas full, repacking from scratch...")
+	}
+
+	return a.repackAll(atlasSize)
+}
+
+// repackAll rebuilds the packing from scratch: for every combination of
+// algorithm and sort order Pack is allowed to try (Algo/SortBy, or all of
+// algoNames/sortNames if unset), it runs a full packAttempt and keeps
+// whichever combination reaches the best occupancy.
+func (a *Atlas) repackAll(atlasSize image.Point) (err error) {
+	for i := range a.Images {
+		a.Images[i].packed = false
+		a.Images[i].Page = 0
+	}
+
+	algos := algoNames
+	if a.Algo != "" {
+		algos = []string{a.Algo}
+	}
+	sorts := sortNames
+	if a.SortBy != "" {
+		sorts = []string{a.SortBy}
+	}
+
+	images := make([]*AtlasImage, len(a.Images))
+	for i := range a.Images {
+		images[i] = &a.Images[i]
+	}
+
+	var bestPages []Packer
+	var bestPlacements []placement
+	var bestAlgo, bestSortBy string
+	bestOccupancy := -1.0
+
+	for _, algo := range algos {
+		for _, sortBy := range sorts {
+			order := sortImagesBy(images, sortBy)
+
+			pages, placements, ierr := packAttempt(atlasSize, a.MaxPages, algo, a.AllowRotate, a.Padding, order)
+			if ierr != nil {
+				continue
+			}
+
+			occ := occupancy(placements, len(pages), atlasSize)
+			if occ > bestOccupancy {
+				bestOccupancy = occ
+				bestPages = pages
+				bestPlacements = placements
+				bestAlgo = algo
+				bestSortBy = sortBy
+			}
 		}
+	}
 
-		r := bestCandidate.Candidate.Insert(images[bestImage].Image.Bounds().Size().Add(image.Pt(1, 1)))
-		images[bestImage].AtlasPos = r.Min
+	if bestPlacements == nil {
+		return fmt.Errorf("Failed to fit all images")
+	}
 
-		images[bestImage] = images[len(images)-1]
-		images = images[0 : len(images)-1]
+	for _, p := range bestPlacements {
+		p.img.AtlasPos = p.pos
+		p.img.Page = p.page
+		p.img.Rotated = p.rotated
+		p.img.packed = true
 	}
 
+	a.pages = bestPages
+	a.atlasSize = atlasSize
+	a.algoUsed = bestAlgo
+
+	fmt.Printf("packed with %s (sort: %s), %.1f%% occupancy\n", bestAlgo, bestSortBy, bestOccupancy*100)
+
 	return nil
 }
 
+// PageOccupancy returns the fraction (0..1) of page's pixel area covered by
+// placed images, for the "occupancy per page" summary.
+func (a *Atlas) PageOccupancy(page int, atlasSize image.Point) float64 {
+	var used int
+	for i := range a.Images {
+		if a.Images[i].Page == page {
+			used += a.Images[i].PixelArea()
+		}
+	}
+
+	total := atlasSize.X * atlasSize.Y
+	if total == 0 {
+		return 0
+	}
+
+	return float64(used) / float64(total)
+}
+
+// pagePath inserts a "_<page>" suffix before path's extension, e.g.
+// "atlas.png" + 1 -> "atlas_1.png". It's only used when the atlas spans
+// more than one page; a single-page atlas keeps the plain path.
+func pagePath(path string, page int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(path, ext), page, ext)
+}
+
+// SaveAtlasImage writes one PNG per atlas page produced by the last Pack
+// call. A single-page atlas is written to path unchanged; a multi-page
+// atlas is written to path with a "_<page>" suffix (atlas_0.png,
+// atlas_1.png, ...).
 func (a *Atlas) SaveAtlasImage(path string, atlasSize image.Point, drawpadding bool) (err error) {
+	numPages := a.NumPages()
+	if numPages == 0 {
+		numPages = 1
+	}
+
+	for page := 0; page < numPages; page++ {
+		dst := path
+		if numPages > 1 {
+			dst = pagePath(path, page)
+		}
+
+		if err = a.saveAtlasPageImage(dst, atlasSize, drawpadding, page); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blitSprite draws src into dst so its top-left lands at pos. When
+// rotated is set, src is rotated 90 degrees counter-clockwise first (it was
+// packed on its side to save space), so dst ends up Dy() wide by Dx()
+// tall instead of the other way around.
+func blitSprite(dst *image.RGBA, pos image.Point, src *image.RGBA, rotated bool) {
+	b := src.Bounds()
+
+	if !rotated {
+		dstrect := image.Rect(pos.X, pos.Y, pos.X+b.Dx(), pos.Y+b.Dy())
+		draw.Draw(dst, dstrect, src, b.Min, draw.Src)
+		return
+	}
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dst.Set(pos.X+y, pos.Y+b.Dx()-1-x, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+}
+
+// extrudeEdges repeats rect's boundary pixels outward into dst's padding,
+// amount pixels deep, so bilinear filtering at the sprite's extreme UVs
+// samples more of itself instead of bleeding in whatever sits next to it.
+// Corners aren't extruded -- diagonal bleed is negligible next to the
+// edges, which is where practically all of it happens.
+func extrudeEdges(dst *image.RGBA, rect image.Rectangle, amount int) {
+	if amount <= 0 {
+		return
+	}
+
+	b := dst.Bounds()
+
+	replicateRow := func(y, srcY int) {
+		if y < b.Min.Y || y >= b.Max.Y {
+			return
+		}
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			dst.Set(x, y, dst.At(x, srcY))
+		}
+	}
+	replicateCol := func(x, srcX int) {
+		if x < b.Min.X || x >= b.Max.X {
+			return
+		}
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			dst.Set(x, y, dst.At(srcX, y))
+		}
+	}
+
+	for i := 1; i <= amount; i++ {
+		replicateRow(rect.Min.Y-i, rect.Min.Y)
+		replicateRow(rect.Max.Y-1+i, rect.Max.Y-1)
+		replicateCol(rect.Min.X-i, rect.Min.X)
+		replicateCol(rect.Max.X-1+i, rect.Max.X-1)
+	}
+}
+
+func (a *Atlas) saveAtlasPageImage(path string, atlasSize image.Point, drawpadding bool, page int) (err error) {
 	dstimg := image.NewRGBA(image.Rect(0, 0, atlasSize.X, atlasSize.Y))
 
 	// fill with solid color
@@ -258,12 +1265,23 @@ func (a *Atlas) SaveAtlasImage(path string, atlasSize image.Point, drawpadding b
 
 	for i := range a.Images {
 		img := a.Images[i]
-		dstrect := image.Rect(img.AtlasPos.X, img.AtlasPos.Y, img.AtlasPos.X+img.Image.Rect.Dx(), img.AtlasPos.Y+img.Image.Rect.Dy())
+		if img.Page != page {
+			continue
+		}
+
+		placedSize := img.Image.Bounds().Size()
+		if img.Rotated {
+			placedSize = image.Pt(placedSize.Y, placedSize.X)
+		}
+		dstrect := image.Rect(img.AtlasPos.X, img.AtlasPos.Y, img.AtlasPos.X+placedSize.X, img.AtlasPos.Y+placedSize.Y)
+
 		if drawpadding {
-			//draw.Draw(dstimg, dstrect.Inset(-1), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.ZP, draw.Src)
-			draw.Draw(dstimg, dstrect.Inset(-1), image.NewUniform(color.RGBA{0, 0, 0, 0}), image.ZP, draw.Src)
+			//draw.Draw(dstimg, dstrect.Inset(-a.Padding), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.ZP, draw.Src)
+			draw.Draw(dstimg, dstrect.Inset(-a.Padding), image.NewUniform(color.RGBA{0, 0, 0, 0}), image.ZP, draw.Src)
 		}
-		draw.Draw(dstimg, dstrect, img.Image, img.Image.Rect.Min, draw.Src)
+
+		blitSprite(dstimg, img.AtlasPos, img.Image, img.Rotated)
+		extrudeEdges(dstimg, dstrect, a.Extrude)
 	}
 
 	f, err := os.Create(path)
@@ -290,64 +1308,340 @@ type Point struct {
 	X, Y int
 }
 
+// UV is a texture coordinate in the 0..1 range.
+type UV struct {
+	U, V float64
+}
+
+// MeshVertex is one vertex of an image's trim mesh: its position in
+// atlas pixel space and the matching UV for sampling the atlas texture.
+type MeshVertex struct {
+	Pos Point
+	UV  UV
+}
+
 type ImageMeta struct {
+	ID           string `json:"id"`
 	Position     Point
 	Size         Dimension
 	OriginalSize Dimension
 	Offset       Point
+	Page         int          `json:"page"`
+	Rotated      bool         `json:"rotated"`
+	Mesh         []MeshVertex `json:"mesh,omitempty"`
 }
 
 type AtlasMeta struct {
-	Size   Dimension
-	Images map[string]ImageMeta
+	Size    Dimension
+	Padding int `json:"padding"`
+	Extrude int `json:"extrude"`
+	Images  map[string]ImageMeta
 }
 
 func (a *Atlas) AtlasMeta(strip int, atlasSize image.Point) (meta AtlasMeta) {
-	meta = AtlasMeta{Size: Dimension{atlasSize.X, atlasSize.Y}, Images: make(map[string]ImageMeta)}
+	meta = AtlasMeta{
+		Size:    Dimension{atlasSize.X, atlasSize.Y},
+		Padding: a.Padding,
+		Extrude: a.Extrude,
+		Images:  make(map[string]ImageMeta),
+	}
 
 	for _, img := range a.Images {
 		path := filepath.Join(strings.Split(img.Path, string(filepath.Separator))[strip:]...)
+
+		width, height := img.Image.Bounds().Dx(), img.Image.Bounds().Dy()
+		if img.Rotated {
+			width, height = height, width
+		}
+
 		meta.Images[path] = ImageMeta{
+			ID:           img.ID,
 			Position:     Point{img.AtlasPos.X, img.AtlasPos.Y},
-			Size:         Dimension{img.Image.Bounds().Dx(), img.Image.Bounds().Dy()},
+			Size:         Dimension{width, height},
 			OriginalSize: Dimension{img.OrgBounds.Dx(), img.OrgBounds.Dy()},
 			Offset:       Point{img.Image.Bounds().Min.X, img.Image.Bounds().Min.Y},
+			Page:         img.Page,
+			Rotated:      img.Rotated,
+			Mesh:         meshVertices(&img, atlasSize),
 		}
 	}
 
 	return
 }
 
+// meshVertices maps img's trim mesh (in local pixel coordinates) to atlas
+// pixel positions and UVs, applying the same rotation blitSprite used to
+// place the image's pixels.
+func meshVertices(img *AtlasImage, atlasSize image.Point) []MeshVertex {
+	if len(img.Mesh) == 0 {
+		return nil
+	}
+
+	b := img.Image.Bounds()
+	verts := make([]MeshVertex, len(img.Mesh))
+	for i, p := range img.Mesh {
+		pos := image.Pt(img.AtlasPos.X+p.X, img.AtlasPos.Y+p.Y)
+		if img.Rotated {
+			pos = image.Pt(img.AtlasPos.X+p.Y, img.AtlasPos.Y+b.Dx()-1-p.X)
+		}
+
+		verts[i] = MeshVertex{
+			Pos: Point{pos.X, pos.Y},
+			UV:  UV{float64(pos.X) / float64(atlasSize.X), float64(pos.Y) / float64(atlasSize.Y)},
+		}
+	}
+	return verts
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
-func (a *Atlas) SaveAtlasMeta(path string, strip int, atlasSize image.Point) (err error) {
+// MetaEncoder renders an AtlasMeta into a particular engine's metadata
+// format. imageNames holds the file name of each atlas page texture
+// (imageNames[page]), as written by SaveAtlasImage, so an encoder can
+// reference the right texture for a region.
+type MetaEncoder interface {
+	// Ext is the conventional file extension for this format, including
+	// the leading dot (e.g. ".plist").
+	Ext() string
+	// Encode writes meta to w.
+	Encode(w io.Writer, meta AtlasMeta, imageNames []string) error
+}
+
+// SaveAtlasMetaWithEncoder writes atlas metadata to path using enc,
+// passing it the page texture file names that SaveAtlasImage would write
+// for imagePath.
+func (a *Atlas) SaveAtlasMetaWithEncoder(enc MetaEncoder, path string, strip int, atlasSize image.Point, imagePath string) (err error) {
 	meta := a.AtlasMeta(strip, atlasSize)
 
-	var f *os.File
-	if f, err = os.Create(path); err == nil {
-		defer f.Close()
-		var body []byte
-		if body, err = json.MarshalIndent(meta, "", "  "); err == nil {
-			_, err = f.Write(body)
+	numPages := a.NumPages()
+	if numPages == 0 {
+		numPages = 1
+	}
+
+	imageNames := make([]string, numPages)
+	for page := range imageNames {
+		dst := imagePath
+		if numPages > 1 {
+			dst = pagePath(imagePath, page)
 		}
+		imageNames[page] = filepath.Base(dst)
 	}
 
+	var f *os.File
+	if f, err = os.Create(path); err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return enc.Encode(f, meta, imageNames)
+}
+
+type jsonMetaEncoder struct{}
+
+func (jsonMetaEncoder) Ext() string { return ".json" }
+
+func (jsonMetaEncoder) Encode(w io.Writer, meta AtlasMeta, imageNames []string) (err error) {
+	var body []byte
+	if body, err = json.MarshalIndent(meta, "", "  "); err == nil {
+		_, err = w.Write(body)
+	}
 	return err
 }
 
+func (a *Atlas) SaveAtlasMeta(path string, strip int, atlasSize image.Point) (err error) {
+	return a.SaveAtlasMetaWithEncoder(jsonMetaEncoder{}, path, strip, atlasSize, path)
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
-func PathAsASVarName(path string) string {
-	//r := []rune(filepath.Base(path))
-	r := []rune(path)
+// sortedImageNames returns meta.Images' keys sorted alphabetically, so
+// encoders that range over them produce the same output byte-for-byte
+// across runs instead of relying on Go's randomized map iteration order.
+func sortedImageNames(meta AtlasMeta) []string {
+	names := make([]string, 0, len(meta.Images))
+	for name := range meta.Images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// libgdxMetaEncoder writes the classic libGDX TextureAtlas text format: a
+// header per page (image file name, size, format) followed by one region
+// block per packed image on that page.
+type libgdxMetaEncoder struct{}
+
+func (libgdxMetaEncoder) Ext() string { return ".atlas" }
+
+func (libgdxMetaEncoder) Encode(w io.Writer, meta AtlasMeta, imageNames []string) error {
+	bw := bufio.NewWriter(w)
+
+	for page, imageName := range imageNames {
+		fmt.Fprintf(bw, "%s\n", imageName)
+		fmt.Fprintf(bw, "size: %d,%d\n", meta.Size.Width, meta.Size.Height)
+		fmt.Fprintf(bw, "format: RGBA8888\n")
+		fmt.Fprintf(bw, "filter: Linear,Linear\n")
+		fmt.Fprintf(bw, "repeat: none\n")
+
+		for _, name := range sortedImageNames(meta) {
+			img := meta.Images[name]
+			if img.Page != page {
+				continue
+			}
+			fmt.Fprintf(bw, "%s\n", name)
+			fmt.Fprintf(bw, "  rotate: %t\n", img.Rotated)
+			fmt.Fprintf(bw, "  xy: %d, %d\n", img.Position.X, img.Position.Y)
+			fmt.Fprintf(bw, "  size: %d, %d\n", img.Size.Width, img.Size.Height)
+			fmt.Fprintf(bw, "  orig: %d, %d\n", img.OriginalSize.Width, img.OriginalSize.Height)
+			fmt.Fprintf(bw, "  offset: %d, %d\n", img.Offset.X, img.Offset.Y)
+			fmt.Fprintf(bw, "  index: -1\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+// plistMetaEncoder writes a cocos2d/SpriteKit-style XML property list,
+// one "frames" entry per packed image. Multi-page atlases only record the
+// first page's texture name in the metadata dict, matching tools of this
+// era that expect one plist per texture.
+type plistMetaEncoder struct{}
+
+func (plistMetaEncoder) Ext() string { return ".plist" }
+
+func plistBool(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+func (plistMetaEncoder) Encode(w io.Writer, meta AtlasMeta, imageNames []string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<!DOCTYPE plist PUBLIC \"-//Apple//DTD PLIST 1.0//EN\" \"http://www.apple.com/DTDs/PropertyList-1.0.dtd\">\n")
+	fmt.Fprintf(bw, "<plist version=\"1.0\">\n<dict>\n\t<key>frames</key>\n\t<dict>\n")
+
+	for _, name := range sortedImageNames(meta) {
+		img := meta.Images[name]
+		fmt.Fprintf(bw, "\t\t<key>%s</key>\n\t\t<dict>\n", name)
+		fmt.Fprintf(bw, "\t\t\t<key>frame</key>\n\t\t\t<string>{{%d,%d},{%d,%d}}</string>\n",
+			img.Position.X, img.Position.Y, img.Size.Width, img.Size.Height)
+		fmt.Fprintf(bw, "\t\t\t<key>offset</key>\n\t\t\t<string>{%d,%d}</string>\n", img.Offset.X, img.Offset.Y)
+		fmt.Fprintf(bw, "\t\t\t<key>rotated</key>\n\t\t\t<%s/>\n", plistBool(img.Rotated))
+		fmt.Fprintf(bw, "\t\t\t<key>sourceSize</key>\n\t\t\t<string>{%d,%d}</string>\n", img.OriginalSize.Width, img.OriginalSize.Height)
+		fmt.Fprintf(bw, "\t\t\t<key>spriteSourceSize</key>\n\t\t\t<string>{%d,%d,%d,%d}</string>\n",
+			img.Offset.X, img.Offset.Y, img.Size.Width, img.Size.Height)
+		fmt.Fprintf(bw, "\t\t</dict>\n")
+	}
+
+	fmt.Fprintf(bw, "\t</dict>\n\t<key>metadata</key>\n\t<dict>\n")
+	fmt.Fprintf(bw, "\t\t<key>format</key>\n\t\t<integer>3</integer>\n")
+	fmt.Fprintf(bw, "\t\t<key>size</key>\n\t\t<string>{%d,%d}</string>\n", meta.Size.Width, meta.Size.Height)
+	if len(imageNames) > 0 {
+		fmt.Fprintf(bw, "\t\t<key>textureFileName</key>\n\t\t<string>%s</string>\n", imageNames[0])
+	}
+	fmt.Fprintf(bw, "\t</dict>\n</dict>\n</plist>\n")
+
+	return bw.Flush()
+}
+
+// cssMetaEncoder writes a generic CSS spritesheet: one rule per atlas
+// page setting its background-image, and one rule per packed image
+// setting the background-position and box size needed to display it.
+type cssMetaEncoder struct{}
+
+func (cssMetaEncoder) Ext() string { return ".css" }
+
+func cssClassName(name string) string {
+	return sanitizeIdent(name, '-')
+}
+
+func (cssMetaEncoder) Encode(w io.Writer, meta AtlasMeta, imageNames []string) error {
+	bw := bufio.NewWriter(w)
+
+	for page, imageName := range imageNames {
+		fmt.Fprintf(bw, ".atlas-page-%d {\n\tbackground-image: url(%s);\n\tbackground-repeat: no-repeat;\n}\n\n", page, imageName)
+	}
+
+	for _, name := range sortedImageNames(meta) {
+		img := meta.Images[name]
+		fmt.Fprintf(bw, ".%s {\n", cssClassName(name))
+		fmt.Fprintf(bw, "\tbackground-position: -%dpx -%dpx;\n", img.Position.X, img.Position.Y)
+		fmt.Fprintf(bw, "\twidth: %dpx;\n\theight: %dpx;\n", img.Size.Width, img.Size.Height)
+		fmt.Fprintf(bw, "}\n\n")
+	}
+
+	return bw.Flush()
+}
+
+// godotMetaEncoder writes a Godot .tres resource: one ext_resource per
+// atlas page texture, one AtlasTexture sub_resource per packed image, and
+// a top-level resource mapping each image's sanitized name to its
+// sub_resource.
+type godotMetaEncoder struct{}
+
+func (godotMetaEncoder) Ext() string { return ".tres" }
+
+func (godotMetaEncoder) Encode(w io.Writer, meta AtlasMeta, imageNames []string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "[gd_resource type=\"Resource\" load_steps=%d format=2]\n\n", len(imageNames)+len(meta.Images)+1)
+
+	for page, imageName := range imageNames {
+		fmt.Fprintf(bw, "[ext_resource path=\"res://%s\" type=\"Texture\" id=%d]\n", imageName, page+1)
+	}
+	fmt.Fprintf(bw, "\n")
+
+	names := sortedImageNames(meta)
+	subIDs := make(map[string]int, len(meta.Images))
+	nextID := 1
+	for _, name := range names {
+		img := meta.Images[name]
+		fmt.Fprintf(bw, "[sub_resource type=\"AtlasTexture\" id=%d]\n", nextID)
+		fmt.Fprintf(bw, "atlas = ExtResource( %d )\n", img.Page+1)
+		fmt.Fprintf(bw, "region = Rect2( %d, %d, %d, %d )\n\n", img.Position.X, img.Position.Y, img.Size.Width, img.Size.Height)
+		subIDs[name] = nextID
+		nextID++
+	}
+
+	fmt.Fprintf(bw, "[resource]\n")
+	for _, name := range names {
+		fmt.Fprintf(bw, "%s = SubResource( %d )\n", sanitizeIdent(name, '_'), subIDs[name])
+	}
+
+	return bw.Flush()
+}
+
+// metaEncoders maps the -format flag values this tool accepts to their
+// MetaEncoder implementation.
+var metaEncoders = map[string]MetaEncoder{
+	"libgdx": libgdxMetaEncoder{},
+	"plist":  plistMetaEncoder{},
+	"css":    cssMetaEncoder{},
+	"godot":  godotMetaEncoder{},
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// sanitizeIdent replaces every rune in s that isn't an ASCII letter or digit
+// with replacement, for use in generated identifiers (AS3 property
+// names, CSS class names, Godot resource keys, ...).
+func sanitizeIdent(s string, replacement rune) string {
+	r := []rune(s)
 	for i := 0; i < len(r); i++ {
 		if !((r[i] >= 'A' && r[i] <= 'Z') || (r[i] >= 'a' && r[i] <= 'z') || (r[i] >= '0' && r[i] <= '9')) {
-			r[i] = '_'
+			r[i] = replacement
 		}
 	}
 	return string(r)
 }
 
+func PathAsASVarName(path string) string {
+	return sanitizeIdent(path, '_')
+}
+
 func CleanASPath(path string) string {
 	return strings.Replace(path, "\\", "/", -1)
 }
@@ -368,7 +1662,9 @@ package {{.package}}
 			*/}}{{$image.OriginalSize.Width}}, {{$image.OriginalSize.Height}}, {{/*
 			*/}}{{$image.Offset.X}}, {{$image.Offset.Y}}, {{/*
 			*/}}{{$image.Position.X}}.0/{{$meta.Size.Width}}.0, {{$image.Position.Y}}.0/{{$meta.Size.Height}}.0, {{/*
-			*/}}({{$image.Position.X}}.0+{{$image.Size.Width}}.0)/{{$meta.Size.Width}}.0, ({{$image.Position.Y}}.0+{{$image.Size.Height}}.0)/{{$meta.Size.Height}}.0 {{/*
+			*/}}({{$image.Position.X}}.0+{{$image.Size.Width}}.0)/{{$meta.Size.Width}}.0, ({{$image.Position.Y}}.0+{{$image.Size.Height}}.0)/{{$meta.Size.Height}}.0, {{/*
+			*/}}{{$image.Page}}, {{/*
+			*/}}{{$image.Rotated}} {{/*
 			*/}});{{end}}
 
 		public static const images:Object = {
@@ -387,7 +1683,9 @@ package {{.package}}
 	{
 		public var x:uint, y:uint, width:uint, height:uint, orgwidth:uint, orgheight:uint, offx:uint, offy:uint;
 		public var u0:Number, v0:Number, u1:Number, v1:Number;
-		public function AtlasImageMeta(x:uint, y:uint, width:uint, height:uint, orgwidth:uint, orgheight:uint, offx:uint, offy:uint, u0:Number, v0:Number, u1:Number, v1:Number)
+		public var page:uint;
+		public var rotated:Boolean;
+		public function AtlasImageMeta(x:uint, y:uint, width:uint, height:uint, orgwidth:uint, orgheight:uint, offx:uint, offy:uint, u0:Number, v0:Number, u1:Number, v1:Number, page:uint, rotated:Boolean)
 		{
 			this.x = x;
 			this.y = y;
@@ -401,6 +1699,8 @@ package {{.package}}
 			this.v0 = v0;
 			this.u1 = u1;
 			this.v1 = v1;
+			this.page = page;
+			this.rotated = rotated;
 		}
 	}
 }
@@ -447,6 +1747,44 @@ var jsonmeta = flag.String("json", "", "save atlas meta as json")
 var as3meta = flag.String("as3", "", "save atlas meta as actionscript")
 var as3name = flag.String("as3name", "Atlas", "package and class name of actionscript object (default Atlas)")
 var strip = flag.Int("strip", 0, "number of path elements to strip")
+var maxpages = flag.Int("pages", 0, "maximum number of atlas pages to produce (0 = unlimited)")
+var algo = flag.String("algo", "", "packing algorithm: guillotine, maxrects-bssf, maxrects-baf, maxrects-bl, skyline (default: try all and keep the best)")
+var sortby = flag.String("sort", "", "pre-pack sort order: area, maxside, perimeter (default: try all and keep the best)")
+var allowrotate = flag.Bool("allowrotate", false, "allow 90-degree rotation of sprites for a tighter pack")
+var padding = flag.Int("padding", 1, "empty pixels to leave between packed images (default 1)")
+var extrude = flag.Int("extrude", 0, "pixels of edge color to repeat into the padding, to avoid filter bleeding (default 0)")
+var premultiply = flag.Bool("premultiply", false, "convert source images to premultiplied alpha before packing")
+var mesh = flag.Bool("mesh", false, "compute a trimmed polygon mesh around each image's non-transparent pixels, for metadata")
+var meshverts = flag.Int("meshverts", 16, "maximum vertex count for -mesh polygons")
+
+// formatList collects repeated -format flag values into a slice.
+type formatList []string
+
+func (f *formatList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *formatList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+var formats formatList
+
+// metaEncoderNames returns metaEncoders' keys sorted alphabetically, for
+// flag usage text and validation messages.
+func metaEncoderNames() []string {
+	names := make([]string, 0, len(metaEncoders))
+	for name := range metaEncoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	flag.Var(&formats, "format", "additional metadata format to write alongside -json/-as3, may be repeated: "+strings.Join(metaEncoderNames(), ", "))
+}
 
 func main() {
 	flag.Parse()
@@ -465,7 +1803,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *algo != "" && !stringInSlice(*algo, algoNames) {
+		fmt.Printf("Unknown -algo %q, must be one of: %s\n", *algo, strings.Join(algoNames, ", "))
+		os.Exit(1)
+	}
+
+	if *sortby != "" && !stringInSlice(*sortby, sortNames) {
+		fmt.Printf("Unknown -sort %q, must be one of: %s\n", *sortby, strings.Join(sortNames, ", "))
+		os.Exit(1)
+	}
+
+	for _, format := range formats {
+		if _, ok := metaEncoders[format]; !ok {
+			fmt.Printf("Unknown -format %q, must be one of: %s\n", format, strings.Join(metaEncoderNames(), ", "))
+			os.Exit(1)
+		}
+	}
+
 	atlas := NewAtlas()
+	atlas.Premultiply = *premultiply
+	atlas.Mesh = *mesh
+	atlas.MeshVerts = *meshverts
 
 	for _, arg := range flag.Args() {
 		err := atlas.AddImages(arg)
@@ -475,12 +1833,22 @@ func main() {
 	}
 
 	altasSize := image.Pt(*atlaswidth, *atlasheight)
-
-	err := atlas.PackImages(altasSize)
+	atlas.MaxPages = *maxpages
+	atlas.Algo = *algo
+	atlas.SortBy = *sortby
+	atlas.AllowRotate = *allowrotate
+	atlas.Padding = *padding
+	atlas.Extrude = *extrude
+
+	err := atlas.Pack(altasSize)
 	if err != nil {
 		panic(err)
 	}
 
+	for page := 0; page < atlas.NumPages(); page++ {
+		fmt.Printf("page %d: %.1f%% occupancy\n", page, atlas.PageOccupancy(page, altasSize)*100)
+	}
+
 	fmt.Printf("Done, writing %s...\n", *atlasfilename)
 
 	err = atlas.SaveAtlasImage(*atlasfilename, altasSize, *drawpadding)
@@ -502,6 +1870,17 @@ func main() {
 			panic(err)
 		}
 	}
+
+	for _, format := range formats {
+		// -format was already validated against metaEncoders above.
+		enc := metaEncoders[format]
+
+		path := strings.TrimSuffix(*atlasfilename, filepath.Ext(*atlasfilename)) + enc.Ext()
+		err = atlas.SaveAtlasMetaWithEncoder(enc, path, *strip, altasSize, *atlasfilename)
+		if err != nil {
+			panic(err)
+		}
+	}
 }
 
 ///////////////////////////////////////////////////////////////////////////////